@@ -0,0 +1,157 @@
+// Package doh 实现DNS over HTTPS客户端，同时支持 RFC 8484 wireformat
+// （application/dns-message）以及 Cloudflare/Google 使用的 JSON API 变体。
+package doh
+
+import (
+    "bytes"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptrace"
+    "net/url"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// Client 是一个可复用的DoH客户端
+type Client struct {
+    HTTPClient *http.Client
+}
+
+// NewClient 创建一个使用指定超时时间的DoH客户端
+func NewClient(timeout time.Duration) *Client {
+    return &Client{HTTPClient: &http.Client{Timeout: timeout}}
+}
+
+// Result 一次DoH查询的结果，HandshakeMs 为TLS握手耗时（没有发生握手时为0）
+type Result struct {
+    Response    *dns.Msg
+    RTT         time.Duration
+    HandshakeMs float64
+}
+
+// withHandshakeTrace 为请求附加 httptrace，用于单独捕获TLS握手耗时
+func withHandshakeTrace(req *http.Request, handshakeMs *float64) *http.Request {
+    var start time.Time
+    trace := &httptrace.ClientTrace{
+        TLSHandshakeStart: func() {
+            start = time.Now()
+        },
+        TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+            *handshakeMs = float64(time.Since(start)) / float64(time.Millisecond)
+        },
+    }
+    return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// ExchangeWireformat 以 RFC 8484 wireformat 协议向 endpoint 发起一次DNS查询
+func (c *Client) ExchangeWireformat(endpoint string, msg *dns.Msg) (*Result, error) {
+    packed, err := msg.Pack()
+    if err != nil {
+        return nil, err
+    }
+
+    req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(packed))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/dns-message")
+    req.Header.Set("Accept", "application/dns-message")
+
+    var handshakeMs float64
+    req = withHandshakeTrace(req, &handshakeMs)
+
+    start := time.Now()
+    resp, err := c.HTTPClient.Do(req)
+    rtt := time.Since(start)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("doh wireformat请求失败，状态码: %d", resp.StatusCode)
+    }
+
+    reply := new(dns.Msg)
+    if err := reply.Unpack(body); err != nil {
+        return nil, err
+    }
+
+    return &Result{Response: reply, RTT: rtt, HandshakeMs: handshakeMs}, nil
+}
+
+// jsonAnswer 对应 JSON API 应答中的单条记录
+type jsonAnswer struct {
+    Name string `json:"name"`
+    Type int    `json:"type"`
+    TTL  int    `json:"TTL"`
+    Data string `json:"data"`
+}
+
+// jsonResponse 对应 Cloudflare（1.1.1.1/dns-query）与 Google（dns.google/resolve）的JSON应答
+type jsonResponse struct {
+    Status int          `json:"Status"`
+    Answer []jsonAnswer `json:"Answer"`
+}
+
+// ExchangeJSON 以 Cloudflare/Google 的 JSON API 变体向 endpoint 发起一次DNS查询
+func (c *Client) ExchangeJSON(endpoint, domain string, qtype uint16) (*Result, error) {
+    u, err := url.Parse(endpoint)
+    if err != nil {
+        return nil, err
+    }
+    q := u.Query()
+    q.Set("name", domain)
+    q.Set("type", dns.TypeToString[qtype])
+    u.RawQuery = q.Encode()
+
+    req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/dns-json")
+
+    var handshakeMs float64
+    req = withHandshakeTrace(req, &handshakeMs)
+
+    start := time.Now()
+    resp, err := c.HTTPClient.Do(req)
+    rtt := time.Since(start)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("doh json请求失败，状态码: %d", resp.StatusCode)
+    }
+
+    var parsed jsonResponse
+    if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+        return nil, err
+    }
+
+    reply := new(dns.Msg)
+    reply.Rcode = parsed.Status
+    for _, a := range parsed.Answer {
+        typeName, ok := dns.TypeToString[uint16(a.Type)]
+        if !ok {
+            continue
+        }
+        rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(a.Name), a.TTL, typeName, a.Data))
+        if err != nil {
+            continue
+        }
+        reply.Answer = append(reply.Answer, rr)
+    }
+
+    return &Result{Response: reply, RTT: rtt, HandshakeMs: handshakeMs}, nil
+}
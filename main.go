@@ -1,21 +1,36 @@
 package main
 
 import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "encoding/csv"
+    "encoding/json"
+    "flag"
     "fmt"
     "math/rand"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
     "sort"
+    "strconv"
     "strings"
     "sync"
     "time"
 
+    "github.com/cheggaaa/pb/v3"
     "github.com/fatih/color"
+    "github.com/miekg/dns"
+    "github.com/sapphira0313/DNS/doh"
 )
 
 // DNSServer 表示DNS服务器信息
 type DNSServer struct {
-    Name   string
-    IP     string
-    Region string
+    Name     string
+    IP       string
+    Region   string
+    Protocol string // udp, tcp, tls（DoT，端口853）, https（DoH，application/dns-message）, doh-json（DoH JSON API）
 }
 
 // TestResult 表示测试结果
@@ -29,49 +44,411 @@ type TestResult struct {
     SuccessCount    int
     Connectivity    bool
     FirstIP         string
+    DownloadSpeed   float64 // MB/s，仅当执行了下载测速时有效
+    HandshakeMs     float64 // TLS握手耗时，仅DoH（https/doh-json）测量；DoT（tls）不测量，恒为0
+}
+
+// DNSQuery 表示一次测试所使用的域名及记录类型
+type DNSQuery struct {
+    Domain string
+    Type   uint16
+}
+
+// FilterOptions 控制结果过滤，字段为零值表示不启用该项过滤
+type FilterOptions struct {
+    MaxAvgLatency   float64 // -tl  平均延迟上限(ms)
+    MinAvgLatency   float64 // -tll 平均延迟下限(ms)
+    MaxLossRatio    float64 // -tlr 最大丢包率(0-1)
+    MinSuccessCount int     // -sl  最小成功次数
+    Region          string  // -region 仅保留指定地区
+    ResultCount     int     // -p   结果数量上限
 }
 
 // DNSTester DNS测试器
 type DNSTester struct {
-    DNSServers  []DNSServer
-    TestDomains []string
+    DNSServers      []DNSServer
+    TestDomains     []string
+    TestQueries     []DNSQuery
+    QueryTimeout    time.Duration
+    Retries         int
+    Filters         FilterOptions
+    IPv6Mode        bool   // -ipv6 仅保留IPv6地址/CIDR
+    AllIP           bool   // -allip 对IPv4 CIDR枚举所有主机，而非每个/24随机取一个
+    DefaultProtocol string // -protocol 通过 -f/-ip 加载时，未用 ip#协议 显式指定协议的条目所使用的默认协议
+}
+
+// protocolSuffixes 是 ip#协议 写法中允许出现在 # 之后的合法协议名
+var protocolSuffixes = map[string]bool{
+    "udp":      true,
+    "tcp":      true,
+    "tls":      true,
+    "https":    true,
+    "doh-json": true,
+}
+
+// splitProtocolSuffix 解析 ip#协议 / cidr#协议 写法，返回去掉协议后缀的地址部分与协议名；
+// 未携带合法协议后缀时，协议名返回空字符串
+func splitProtocolSuffix(entry string) (addr string, protocol string) {
+    idx := strings.LastIndex(entry, "#")
+    if idx < 0 {
+        return entry, ""
+    }
+    candidate := strings.ToLower(strings.TrimSpace(entry[idx+1:]))
+    if !protocolSuffixes[candidate] {
+        return entry, ""
+    }
+    return strings.TrimSpace(entry[:idx]), candidate
+}
+
+// LoadFromFile 按行读取自定义DNS列表文件，每行一个IP地址或CIDR段（可加 #协议 后缀，见 LoadFromCIDR）
+func (dt *DNSTester) LoadFromFile(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    entries := make([]string, 0)
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        entries = append(entries, line)
+    }
+    if err := scanner.Err(); err != nil {
+        return err
+    }
+
+    return dt.LoadFromCIDR(entries)
+}
+
+// LoadFromCIDR 将一组IP或CIDR段展开为DNS服务器列表；IPv4 CIDR默认每个/24随机取一个主机
+// （-allip 时枚举全部主机），IPv6 CIDR随机化低64位后取样一个地址。
+// 每个条目可用 ip#协议 或 cidr#协议（协议为 udp/tcp/tls/https/doh-json）单独指定传输协议，
+// 未指定时使用 dt.DefaultProtocol（留空则为 udp）
+func (dt *DNSTester) LoadFromCIDR(entries []string) error {
+    servers := make([]DNSServer, 0, len(entries))
+
+    for _, entry := range entries {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+
+        addr, protocol := splitProtocolSuffix(entry)
+        if protocol == "" {
+            protocol = dt.DefaultProtocol
+        }
+        if protocol == "" {
+            protocol = "udp"
+        }
+
+        if ip := net.ParseIP(addr); ip != nil {
+            isV6 := ip.To4() == nil
+            if dt.IPv6Mode != isV6 {
+                continue
+            }
+            servers = append(servers, DNSServer{Name: addr, IP: addr, Region: "Custom", Protocol: protocol})
+            continue
+        }
+
+        _, ipNet, err := net.ParseCIDR(addr)
+        if err != nil {
+            continue
+        }
+
+        isV6 := ipNet.IP.To4() == nil
+        if dt.IPv6Mode != isV6 {
+            continue
+        }
+
+        if isV6 {
+            ip := randomIPv6Host(ipNet)
+            servers = append(servers, DNSServer{Name: ip.String(), IP: ip.String(), Region: "Custom", Protocol: protocol})
+            continue
+        }
+
+        for _, ip := range expandIPv4CIDR(ipNet, dt.AllIP) {
+            servers = append(servers, DNSServer{Name: ip.String(), IP: ip.String(), Region: "Custom", Protocol: protocol})
+        }
+    }
+
+    dt.DNSServers = servers
+    return nil
+}
+
+// expandIPv4CIDR 展开IPv4 CIDR：allIP为true时枚举全部主机，否则每个/24随机取一个主机
+func expandIPv4CIDR(ipNet *net.IPNet, allIP bool) []net.IP {
+    if allIP {
+        return enumerateIPv4Hosts(ipNet)
+    }
+
+    ones, _ := ipNet.Mask.Size()
+    if ones >= 24 {
+        return []net.IP{randomIPv4Host(ipNet)}
+    }
+
+    ips := make([]net.IP, 0)
+    for _, subnet := range splitIntoSlash24s(ipNet) {
+        ips = append(ips, randomIPv4Host(subnet))
+    }
+    return ips
+}
+
+// enumerateIPv4Hosts 枚举CIDR内的全部主机地址
+func enumerateIPv4Hosts(ipNet *net.IPNet) []net.IP {
+    ips := make([]net.IP, 0)
+    for ip := cloneIP(ipNet.IP.Mask(ipNet.Mask)); ipNet.Contains(ip); incIP(ip) {
+        ips = append(ips, cloneIP(ip))
+    }
+    return ips
+}
+
+// splitIntoSlash24s 将一个更大的IPv4 CIDR拆分为一组 /24 子网
+func splitIntoSlash24s(ipNet *net.IPNet) []*net.IPNet {
+    ones, _ := ipNet.Mask.Size()
+    base := ipv4ToUint32(ipNet.IP.Mask(ipNet.Mask).To4())
+    count := 1 << uint(24-ones)
+
+    subnets := make([]*net.IPNet, 0, count)
+    for i := 0; i < count; i++ {
+        subnets = append(subnets, &net.IPNet{
+            IP:   uint32ToIPv4(base + uint32(i)<<8),
+            Mask: net.CIDRMask(24, 32),
+        })
+    }
+    return subnets
+}
+
+// randomIPv4Host 在给定IPv4网段内随机取一个主机地址
+func randomIPv4Host(ipNet *net.IPNet) net.IP {
+    ones, bits := ipNet.Mask.Size()
+    hostBits := bits - ones
+    base := ipv4ToUint32(ipNet.IP.Mask(ipNet.Mask).To4())
+    if hostBits <= 0 {
+        return uint32ToIPv4(base)
+    }
+    offset := uint32(rand.Int63n(int64(1) << uint(hostBits)))
+    return uint32ToIPv4(base + offset)
+}
+
+// randomIPv6Host 随机化IPv6网段的低64位，在地址段内取样一个地址
+func randomIPv6Host(ipNet *net.IPNet) net.IP {
+    ip := cloneIP(ipNet.IP.Mask(ipNet.Mask)).To16()
+    for i := 8; i < 16; i++ {
+        ip[i] = byte(rand.Intn(256))
+    }
+    return ip
+}
+
+func ipv4ToUint32(ip net.IP) uint32 {
+    ip = ip.To4()
+    return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIPv4(v uint32) net.IP {
+    return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func cloneIP(ip net.IP) net.IP {
+    dup := make(net.IP, len(ip))
+    copy(dup, ip)
+    return dup
+}
+
+func incIP(ip net.IP) {
+    for i := len(ip) - 1; i >= 0; i-- {
+        ip[i]++
+        if ip[i] != 0 {
+            break
+        }
+    }
 }
 
 // LoadCustomDNS 加载自定义DNS服务器列表
 func (dt *DNSTester) LoadCustomDNS() {
     defaultDNS := []DNSServer{
-        {Name: "Google DNS", IP: "8.8.8.8", Region: "Global"},
-        {Name: "Google DNS", IP: "8.8.4.4", Region: "Global"},
-        {Name: "Cloudflare DNS", IP: "1.1.1.1", Region: "Global"},
-        {Name: "Cloudflare DNS", IP: "1.0.0.1", Region: "Global"},
-        {Name: "OpenDNS", IP: "208.67.222.222", Region: "Global"},
-        {Name: "Quad9", IP: "9.9.9.9", Region: "Global"},
-        {Name: "阿里DNS", IP: "223.5.5.5", Region: "China"},
-        {Name: "阿里DNS", IP: "223.6.6.6", Region: "China"},
-        {Name: "腾讯DNS", IP: "119.29.29.29", Region: "China"},
-        {Name: "114 DNS", IP: "114.114.114.114", Region: "China"},
-        {Name: "百度DNS", IP: "180.76.76.76", Region: "China"},
-        {Name: "CNNIC DNS", IP: "1.2.4.8", Region: "China"},
+        {Name: "Google DNS", IP: "8.8.8.8", Region: "Global", Protocol: "udp"},
+        {Name: "Google DNS", IP: "8.8.4.4", Region: "Global", Protocol: "udp"},
+        {Name: "Cloudflare DNS", IP: "1.1.1.1", Region: "Global", Protocol: "udp"},
+        {Name: "Cloudflare DNS", IP: "1.0.0.1", Region: "Global", Protocol: "udp"},
+        {Name: "OpenDNS", IP: "208.67.222.222", Region: "Global", Protocol: "udp"},
+        {Name: "Quad9", IP: "9.9.9.9", Region: "Global", Protocol: "udp"},
+        {Name: "阿里DNS", IP: "223.5.5.5", Region: "China", Protocol: "udp"},
+        {Name: "阿里DNS", IP: "223.6.6.6", Region: "China", Protocol: "udp"},
+        {Name: "腾讯DNS", IP: "119.29.29.29", Region: "China", Protocol: "udp"},
+        {Name: "114 DNS", IP: "114.114.114.114", Region: "China", Protocol: "udp"},
+        {Name: "百度DNS", IP: "180.76.76.76", Region: "China", Protocol: "udp"},
+        {Name: "CNNIC DNS", IP: "1.2.4.8", Region: "China", Protocol: "udp"},
+        {Name: "Cloudflare DoH", IP: "https://1.1.1.1/dns-query", Region: "Global", Protocol: "doh-json"},
+        {Name: "Google DoH", IP: "https://dns.google/resolve", Region: "Global", Protocol: "doh-json"},
     }
 
     dt.DNSServers = defaultDNS
     dt.TestDomains = []string{"www.google.com", "www.baidu.com", "www.qq.com", "www.taobao.com"}
+    dt.TestQueries = []DNSQuery{
+        {Domain: "www.google.com", Type: dns.TypeA},
+        {Domain: "www.baidu.com", Type: dns.TypeA},
+        {Domain: "www.qq.com", Type: dns.TypeA},
+    }
+
+    if dt.QueryTimeout == 0 {
+        dt.QueryTimeout = 2 * time.Second
+    }
+    if dt.Retries == 0 {
+        dt.Retries = 1
+    }
+}
+
+// exchangeOverUDPOrTCP 通过标准UDP/TCP发起一次DNS查询
+func (dt *DNSTester) exchangeOverUDPOrTCP(server DNSServer, msg *dns.Msg, network string) (*dns.Msg, time.Duration, error) {
+    client := &dns.Client{Net: network, Timeout: dt.QueryTimeout}
+    addr := server.IP + ":53"
+    resp, rtt, err := client.Exchange(msg, addr)
+    return resp, rtt, err
+}
+
+// exchangeOverDoT 通过DNS over TLS（853端口）发起一次DNS查询
+func (dt *DNSTester) exchangeOverDoT(server DNSServer, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+    client := &dns.Client{Net: "tcp-tls", Timeout: dt.QueryTimeout}
+    addr := server.IP + ":853"
+    resp, rtt, err := client.Exchange(msg, addr)
+    return resp, rtt, err
+}
+
+// dohEndpoint 将 server.IP 规范化为DoH请求的完整URL
+func dohEndpoint(server DNSServer) string {
+    if strings.Contains(server.IP, "://") {
+        return server.IP
+    }
+    return fmt.Sprintf("https://%s/dns-query", server.IP)
+}
+
+// exchangeOverDoH 通过DNS over HTTPS（RFC 8484 wireformat）发起一次DNS查询
+func (dt *DNSTester) exchangeOverDoH(server DNSServer, msg *dns.Msg) (*dns.Msg, time.Duration, float64, error) {
+    client := doh.NewClient(dt.QueryTimeout)
+    result, err := client.ExchangeWireformat(dohEndpoint(server), msg)
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    return result.Response, result.RTT, result.HandshakeMs, nil
+}
+
+// exchangeOverDoHJSON 通过 Cloudflare/Google 使用的DoH JSON API 发起一次DNS查询
+func (dt *DNSTester) exchangeOverDoHJSON(server DNSServer, query DNSQuery) (*dns.Msg, time.Duration, float64, error) {
+    client := doh.NewClient(dt.QueryTimeout)
+    result, err := client.ExchangeJSON(dohEndpoint(server), query.Domain, query.Type)
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    return result.Response, result.RTT, result.HandshakeMs, nil
 }
 
-// MockTestDNSResponseTime 模拟DNS测试
-func (dt *DNSTester) MockTestDNSResponseTime(server DNSServer, domain string) TestResult {
-    // 模拟DNS查询延迟
-    times := make([]float64, 0, 3)
+// exchange 按照 server.Protocol 选择合适的传输方式发起一次查询
+func (dt *DNSTester) exchange(server DNSServer, query DNSQuery) (*dns.Msg, time.Duration, float64, error) {
+    switch server.Protocol {
+    case "doh-json":
+        return dt.exchangeOverDoHJSON(server, query)
+    }
+
+    msg := new(dns.Msg)
+    msg.SetQuestion(dns.Fqdn(query.Domain), query.Type)
+    msg.RecursionDesired = true
 
-    for i := 0; i < 3; i++ {
-        // 生成随机响应时间（模拟真实情况）
-        baseTime := rand.Float64() * 200 // 0-200ms
-        jitter := rand.Float64() * 20   // 额外抖动
-        responseTime := baseTime + jitter
-        times = append(times, responseTime)
+    switch server.Protocol {
+    case "tcp":
+        resp, rtt, err := dt.exchangeOverUDPOrTCP(server, msg, "tcp")
+        return resp, rtt, 0, err
+    case "tls":
+        resp, rtt, err := dt.exchangeOverDoT(server, msg)
+        return resp, rtt, 0, err
+    case "https":
+        return dt.exchangeOverDoH(server, msg)
+    default:
+        resp, rtt, err := dt.exchangeOverUDPOrTCP(server, msg, "udp")
+        return resp, rtt, 0, err
+    }
+}
+
+// firstAddress 从应答中解析出第一个 A/AAAA 记录
+func firstAddress(resp *dns.Msg) string {
+    if resp == nil {
+        return ""
+    }
+    for _, rr := range resp.Answer {
+        switch rec := rr.(type) {
+        case *dns.A:
+            return rec.A.String()
+        case *dns.AAAA:
+            return rec.AAAA.String()
+        }
+    }
+    return ""
+}
+
+// TestDNSResponseTime 对单个DNS服务器发起真实查询，测量往返时延
+func (dt *DNSTester) TestDNSResponseTime(server DNSServer) TestResult {
+    queries := dt.TestQueries
+    if len(queries) == 0 {
+        queries = []DNSQuery{{Domain: "www.google.com", Type: dns.TypeA}}
+    }
+
+    times := make([]float64, 0, len(queries))
+    var firstIP string
+    var handshakeMs float64
+    successCount := 0
+    status := "Success"
+
+    for _, query := range queries {
+        var resp *dns.Msg
+        var rtt time.Duration
+        var handshake float64
+        var err error
+
+        for attempt := 0; attempt <= dt.Retries; attempt++ {
+            resp, rtt, handshake, err = dt.exchange(server, query)
+            if err == nil {
+                break
+            }
+        }
+
+        if err != nil {
+            status = "Timeout"
+            continue
+        }
+        if resp.Rcode == dns.RcodeServerFailure {
+            status = "SERVFAIL"
+            continue
+        }
+        if resp.Rcode != dns.RcodeSuccess {
+            status = fmt.Sprintf("Rcode:%d", resp.Rcode)
+            continue
+        }
+
+        times = append(times, float64(rtt)/float64(time.Millisecond))
+        successCount++
+        if firstIP == "" {
+            firstIP = firstAddress(resp)
+        }
+        if handshakeMs == 0 {
+            handshakeMs = handshake
+        }
+    }
+
+    if successCount == 0 {
+        return TestResult{
+            Server:          server,
+            AvgResponseTime: -1,
+            MinResponseTime: -1,
+            MaxResponseTime: -1,
+            Status:          status,
+            SuccessCount:    0,
+            Connectivity:    false,
+        }
     }
 
-    // 计算统计数据
     var sum float64
     min := times[0]
     max := times[0]
@@ -86,9 +463,6 @@ func (dt *DNSTester) MockTestDNSResponseTime(server DNSServer, domain string) Te
     }
     avg := sum / float64(len(times))
 
-    // 模拟连通性测试
-    connectivity := rand.Intn(2) == 1 // 50% 概率连通
-
     return TestResult{
         Server:          server,
         AvgResponseTime: avg,
@@ -96,12 +470,26 @@ func (dt *DNSTester) MockTestDNSResponseTime(server DNSServer, domain string) Te
         MaxResponseTime: max,
         ResponseTimes:   times,
         Status:          "Success",
-        SuccessCount:    len(times),
-        Connectivity:    connectivity,
-        FirstIP:         fmt.Sprintf("192.168.%d.%d", rand.Intn(255), rand.Intn(255)),
+        SuccessCount:    successCount,
+        Connectivity:    successCount == len(queries),
+        FirstIP:         firstIP,
+        HandshakeMs:     handshakeMs,
     }
 }
 
+// sortByLatency 按平均响应时间排序，超时/错误的结果排在最后
+func sortByLatency(results []TestResult) {
+    sort.Slice(results, func(i, j int) bool {
+        if results[i].AvgResponseTime == float64(-1) {
+            return false
+        }
+        if results[j].AvgResponseTime == float64(-1) {
+            return true
+        }
+        return results[i].AvgResponseTime < results[j].AvgResponseTime
+    })
+}
+
 // TestAllDNS 测试所有DNS服务器
 func (dt *DNSTester) TestAllDNS(concurrency int) []TestResult {
     results := make([]TestResult, 0, len(dt.DNSServers))
@@ -110,6 +498,9 @@ func (dt *DNSTester) TestAllDNS(concurrency int) []TestResult {
 
     semaphore := make(chan struct{}, concurrency)
 
+    bar := pb.StartNew(len(dt.DNSServers))
+    bar.SetTemplateString(`{{counters . }} {{bar . }} {{percent . }} {{etime . }} {{string . "current"}}`)
+
     for _, server := range dt.DNSServers {
         wg.Add(1)
         semaphore <- struct{}{} // 获取信号量
@@ -118,38 +509,371 @@ func (dt *DNSTester) TestAllDNS(concurrency int) []TestResult {
             defer wg.Done()
             defer func() { <-semaphore }() // 释放信号量
 
-            result := dt.MockTestDNSResponseTime(s, dt.TestDomains[0])
+            result := dt.TestDNSResponseTime(s)
 
             mu.Lock()
             results = append(results, result)
             mu.Unlock()
 
-            // 打印进度
-            fmt.Print(".")
+            bar.Set("current", s.Name)
+            bar.Increment()
         }(server)
     }
 
     wg.Wait()
+    bar.Finish()
     close(semaphore)
 
-    // 按平均响应时间排序
-    sort.Slice(results, func(i, j int) bool {
-        if results[i].AvgResponseTime == float64(-1) {
-            return false
+    sortByLatency(results)
+
+    return results
+}
+
+// renderLiveSnapshot 对当前已完成的结果做一次快照、排序并原地重绘，供实时模式滚动展示
+func (dt *DNSTester) renderLiveSnapshot(results *[]TestResult, mu *sync.Mutex) {
+    mu.Lock()
+    snapshot := make([]TestResult, len(*results))
+    copy(snapshot, *results)
+    mu.Unlock()
+
+    sortByLatency(snapshot)
+
+    fmt.Print("\033[H\033[2J") // 清屏并回到左上角，实现原地重绘
+    fmt.Printf("实时DNS测速排名（已完成 %d/%d，可按 Ctrl-C 提前退出）\n", len(snapshot), len(dt.DNSServers))
+    for i, r := range snapshot {
+        if i >= 10 {
+            break
         }
-        if results[j].AvgResponseTime == float64(-1) {
-            return true
+        avg := "-"
+        if r.AvgResponseTime != float64(-1) {
+            avg = fmt.Sprintf("%.2fms", r.AvgResponseTime)
         }
-        return results[i].AvgResponseTime < results[j].AvgResponseTime
-    })
+        fmt.Printf("%2d. %-20s %-15s %s\n", i+1, r.Server.Name, r.Server.IP, avg)
+    }
+}
+
+// TestAllDNSLive 与 TestAllDNS 等价，但每隔 refreshInterval 重绘一次当前排名，
+// 便于用户在出现满意的服务器后提前结束测试
+func (dt *DNSTester) TestAllDNSLive(concurrency int, refreshInterval time.Duration) []TestResult {
+    results := make([]TestResult, 0, len(dt.DNSServers))
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+
+    semaphore := make(chan struct{}, concurrency)
+    done := make(chan struct{})
+    renderStopped := make(chan struct{})
+
+    go func() {
+        defer close(renderStopped)
+        ticker := time.NewTicker(refreshInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-done:
+                dt.renderLiveSnapshot(&results, &mu)
+                return
+            case <-ticker.C:
+                dt.renderLiveSnapshot(&results, &mu)
+            }
+        }
+    }()
+
+    for _, server := range dt.DNSServers {
+        wg.Add(1)
+        semaphore <- struct{}{} // 获取信号量
+
+        go func(s DNSServer) {
+            defer wg.Done()
+            defer func() { <-semaphore }() // 释放信号量
+
+            result := dt.TestDNSResponseTime(s)
+
+            mu.Lock()
+            results = append(results, result)
+            mu.Unlock()
+        }(server)
+    }
+
+    wg.Wait()
+    close(semaphore)
+    close(done)
+    <-renderStopped // 等待最后一次重绘真正完成，避免与后续输出交叉
+
+    sortByLatency(results)
 
     return results
 }
 
-// DisplayResults 显示测试结果
+// dnsWireDialer 返回一个按 server.Protocol 建立底层连接的拨号函数，供 net.Resolver
+// 复用，仅适用于说标准DNS报文格式的传输（udp/tcp/tls-DoT）
+func (dt *DNSTester) dnsWireDialer(server DNSServer) func(ctx context.Context, network, address string) (net.Conn, error) {
+    return func(ctx context.Context, network, address string) (net.Conn, error) {
+        switch server.Protocol {
+        case "tcp":
+            d := net.Dialer{Timeout: dt.QueryTimeout}
+            return d.DialContext(ctx, "tcp", server.IP+":53")
+        case "tls":
+            d := tls.Dialer{NetDialer: &net.Dialer{Timeout: dt.QueryTimeout}}
+            return d.DialContext(ctx, "tcp", server.IP+":853")
+        default:
+            d := net.Dialer{Timeout: dt.QueryTimeout}
+            return d.DialContext(ctx, "udp", server.IP+":53")
+        }
+    }
+}
+
+// resolveViaDoH 通过 DoH（wireformat或JSON API）解析 host，返回第一个 A/AAAA 地址
+func (dt *DNSTester) resolveViaDoH(server DNSServer, host string) (string, error) {
+    resp, _, _, err := dt.exchange(server, DNSQuery{Domain: host, Type: dns.TypeA})
+    if err != nil {
+        return "", err
+    }
+    ip := firstAddress(resp)
+    if ip == "" {
+        return "", fmt.Errorf("通过 %s 解析 %s 未获得可用地址", server.Name, host)
+    }
+    return ip, nil
+}
+
+// measureDownloadSpeed 通过指定DNS服务器解析下载地址，并实测一段时间内的下载速度(MB/s)；
+// 解析路径按 server.Protocol 区分：udp/tcp/tls走标准DNS报文拨号，https/doh-json走DoH客户端
+func (dt *DNSTester) measureDownloadSpeed(server DNSServer, targetURL string, duration time.Duration) float64 {
+    resolver := &net.Resolver{
+        PreferGo: true,
+        Dial:     dt.dnsWireDialer(server),
+    }
+
+    client := &http.Client{
+        Timeout: duration + 10*time.Second,
+        Transport: &http.Transport{
+            DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+                host, port, err := net.SplitHostPort(addr)
+                if err != nil {
+                    return nil, err
+                }
+
+                var resolvedIP string
+                switch server.Protocol {
+                case "https", "doh-json":
+                    resolvedIP, err = dt.resolveViaDoH(server, host)
+                default:
+                    ipAddrs, lookupErr := resolver.LookupIPAddr(ctx, host)
+                    if lookupErr != nil || len(ipAddrs) == 0 {
+                        err = fmt.Errorf("通过 %s 解析 %s 失败", server.IP, host)
+                    } else {
+                        resolvedIP = ipAddrs[0].IP.String()
+                    }
+                }
+                if err != nil {
+                    return nil, err
+                }
+
+                d := net.Dialer{Timeout: dt.QueryTimeout}
+                return d.DialContext(ctx, network, net.JoinHostPort(resolvedIP, port))
+            },
+        },
+    }
+
+    resp, err := client.Get(targetURL)
+    if err != nil {
+        return -1
+    }
+    defer resp.Body.Close()
+
+    buf := make([]byte, 32*1024)
+    var totalBytes int64
+    start := time.Now()
+    deadline := start.Add(duration)
+    for time.Now().Before(deadline) {
+        n, readErr := resp.Body.Read(buf)
+        totalBytes += int64(n)
+        if readErr != nil {
+            break
+        }
+    }
+
+    elapsed := time.Since(start).Seconds()
+    if elapsed <= 0 {
+        return 0
+    }
+    return float64(totalBytes) / elapsed / (1024 * 1024)
+}
+
+// DownloadTest 对延迟排名前 topN 的DNS服务器执行下载测速，并按下载速度重新排序
+func (dt *DNSTester) DownloadTest(results []TestResult, topN int, targetURL string, duration time.Duration) []TestResult {
+    if topN > len(results) {
+        topN = len(results)
+    }
+    candidates := results[:topN]
+
+    for i := range candidates {
+        candidates[i].DownloadSpeed = dt.measureDownloadSpeed(candidates[i].Server, targetURL, duration)
+    }
+
+    sort.Slice(candidates, func(i, j int) bool {
+        return candidates[i].DownloadSpeed > candidates[j].DownloadSpeed
+    })
+
+    return candidates
+}
+
+// exportRecord 是结果导出为CSV/JSON时的行格式
+type exportRecord struct {
+    Rank         int     `json:"rank"`
+    Name         string  `json:"name"`
+    IP           string  `json:"ip"`
+    Region       string  `json:"region"`
+    Protocol     string  `json:"protocol"`
+    AvgMs        float64 `json:"avg_ms"`
+    MinMs        float64 `json:"min_ms"`
+    MaxMs        float64 `json:"max_ms"`
+    Loss         float64 `json:"loss"`
+    FirstIP      string  `json:"first_ip"`
+    DownloadMbps float64 `json:"download_mbps"`
+    Status       string  `json:"status"`
+}
+
+// toExportRecords 将测试结果转换为导出行格式
+func (dt *DNSTester) toExportRecords(results []TestResult) []exportRecord {
+    totalQueries := len(dt.TestQueries)
+    if totalQueries == 0 {
+        totalQueries = 1
+    }
+
+    records := make([]exportRecord, 0, len(results))
+    for i, r := range results {
+        loss := 1 - float64(r.SuccessCount)/float64(totalQueries)
+        if r.AvgResponseTime == float64(-1) {
+            loss = 1
+        }
+        records = append(records, exportRecord{
+            Rank:         i + 1,
+            Name:         r.Server.Name,
+            IP:           r.Server.IP,
+            Region:       r.Server.Region,
+            Protocol:     r.Server.Protocol,
+            AvgMs:        r.AvgResponseTime,
+            MinMs:        r.MinResponseTime,
+            MaxMs:        r.MaxResponseTime,
+            Loss:         loss,
+            FirstIP:      r.FirstIP,
+            DownloadMbps: r.DownloadSpeed,
+            Status:       r.Status,
+        })
+    }
+    return records
+}
+
+// exportCSV 将导出行写为CSV文件
+func (dt *DNSTester) exportCSV(records []exportRecord, path string) error {
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    w := csv.NewWriter(f)
+    defer w.Flush()
+
+    header := []string{"rank", "name", "ip", "region", "protocol", "avg_ms", "min_ms", "max_ms", "loss", "first_ip", "download_mbps", "status"}
+    if err := w.Write(header); err != nil {
+        return err
+    }
+
+    for _, rec := range records {
+        row := []string{
+            strconv.Itoa(rec.Rank),
+            rec.Name,
+            rec.IP,
+            rec.Region,
+            rec.Protocol,
+            strconv.FormatFloat(rec.AvgMs, 'f', 2, 64),
+            strconv.FormatFloat(rec.MinMs, 'f', 2, 64),
+            strconv.FormatFloat(rec.MaxMs, 'f', 2, 64),
+            strconv.FormatFloat(rec.Loss, 'f', 2, 64),
+            rec.FirstIP,
+            strconv.FormatFloat(rec.DownloadMbps, 'f', 2, 64),
+            rec.Status,
+        }
+        if err := w.Write(row); err != nil {
+            return err
+        }
+    }
+    return w.Error()
+}
+
+// exportJSON 将导出行写为JSON文件
+func (dt *DNSTester) exportJSON(records []exportRecord, path string) error {
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    enc := json.NewEncoder(f)
+    enc.SetIndent("", "  ")
+    return enc.Encode(records)
+}
+
+// ExportResults 将排序后的测试结果导出为CSV或JSON文件，格式由扩展名决定；path为空白时不导出
+func (dt *DNSTester) ExportResults(results []TestResult, path string) error {
+    if strings.TrimSpace(path) == "" {
+        return nil
+    }
+
+    records := dt.toExportRecords(results)
+
+    if strings.ToLower(filepath.Ext(path)) == ".json" {
+        return dt.exportJSON(records, path)
+    }
+    return dt.exportCSV(records, path)
+}
+
+// applyFilters 按 Filters 中配置的阈值过滤结果，零值字段表示不启用
+func (dt *DNSTester) applyFilters(results []TestResult) []TestResult {
+    totalQueries := len(dt.TestQueries)
+    if totalQueries == 0 {
+        totalQueries = 1
+    }
+
+    filtered := make([]TestResult, 0, len(results))
+    for _, r := range results {
+        if dt.Filters.Region != "" && r.Server.Region != dt.Filters.Region {
+            continue
+        }
+        if r.AvgResponseTime == float64(-1) && (dt.Filters.MaxAvgLatency > 0 || dt.Filters.MinAvgLatency > 0 ||
+            dt.Filters.MaxLossRatio > 0 || dt.Filters.MinSuccessCount > 0) {
+            continue
+        }
+        if dt.Filters.MaxAvgLatency > 0 && r.AvgResponseTime > dt.Filters.MaxAvgLatency {
+            continue
+        }
+        if dt.Filters.MinAvgLatency > 0 && r.AvgResponseTime < dt.Filters.MinAvgLatency {
+            continue
+        }
+        lossRatio := 1 - float64(r.SuccessCount)/float64(totalQueries)
+        if dt.Filters.MaxLossRatio > 0 && lossRatio > dt.Filters.MaxLossRatio {
+            continue
+        }
+        if dt.Filters.MinSuccessCount > 0 && r.SuccessCount < dt.Filters.MinSuccessCount {
+            continue
+        }
+        filtered = append(filtered, r)
+    }
+
+    if dt.Filters.ResultCount > 0 && len(filtered) > dt.Filters.ResultCount {
+        filtered = filtered[:dt.Filters.ResultCount]
+    }
+
+    return filtered
+}
+
+// DisplayResults 显示测试结果（已按 Filters 过滤）
 func (dt *DNSTester) DisplayResults(results []TestResult) {
+    results = dt.applyFilters(results)
+
     fmt.Println()
-    fmt.Println("DNS服务器速度测试结果（云端模拟版）")
+    fmt.Println("DNS服务器速度测试结果")
     fmt.Println("=" + strings.Repeat("=", 100))
 
     headerFormat := "%-4s %-20s %-15s %-8s %-15s %-15s %-10s %-10s %-10s\n"
@@ -171,7 +895,7 @@ func (dt *DNSTester) DisplayResults(results []TestResult) {
         } else {
             avgTimeStr = fmt.Sprintf("%.2fms", result.AvgResponseTime)
             minMaxStr = fmt.Sprintf("%.2f/%.2f", result.MinResponseTime, result.MaxResponseTime)
-            successRate = fmt.Sprintf("%d%%", result.SuccessCount*100/3)
+            successRate = fmt.Sprintf("%d%%", result.SuccessCount*100/len(dt.TestQueries))
             if result.Connectivity {
                 connectivity = "✅"
             } else {
@@ -198,15 +922,22 @@ func (dt *DNSTester) DisplayResults(results []TestResult) {
     }
 }
 
-// GetBestDNS 获取最快的几个DNS服务器
+// GetBestDNS 获取最快的几个DNS服务器（已按 Filters 过滤）
 func (dt *DNSTester) GetBestDNS(results []TestResult, count int) []TestResult {
     validResults := make([]TestResult, 0)
-    for _, r := range results {
-        if r.AvgResponseTime != float64(-1) && r.SuccessCount > 0 {
+    for _, r := range dt.applyFilters(results) {
+        if r.SuccessCount > 0 {
             validResults = append(validResults, r)
         }
     }
 
+    if dt.Filters.ResultCount > 0 && dt.Filters.ResultCount < count {
+        count = dt.Filters.ResultCount
+    }
+    if count < 0 {
+        count = 0
+    }
+
     if len(validResults) < count {
         return validResults
     }
@@ -215,38 +946,118 @@ func (dt *DNSTester) GetBestDNS(results []TestResult, count int) []TestResult {
 
 func main() {
     rand.Seed(time.Now().UnixNano())
-    
-    color.Cyan("DNS服务器速度测试工具 (云端模拟版)")
+
+    maxAvgLatency := flag.Float64("tl", 0, "平均延迟上限(ms)，0表示不限制")
+    minAvgLatency := flag.Float64("tll", 0, "平均延迟下限(ms)")
+    maxLossRatio := flag.Float64("tlr", 0, "最大丢包率(0-1)，0表示不限制")
+    minSuccessCount := flag.Int("sl", 0, "最小成功次数")
+    region := flag.String("region", "", "仅显示指定地区，如 China")
+    resultCount := flag.Int("p", 3, "显示结果数量上限")
+    concurrency := flag.Int("n", 10, "并发数")
+    dnsFile := flag.String("f", "", "自定义DNS列表文件路径，每行一个IP或CIDR")
+    ipList := flag.String("ip", "", "自定义IP/CIDR列表，逗号分隔")
+    ipv6Mode := flag.Bool("ipv6", false, "IPv6模式，仅保留IPv6地址/CIDR")
+    allIP := flag.Bool("allip", false, "对IPv4 CIDR枚举所有主机，而不是每个/24随机取一个")
+    protocol := flag.String("protocol", "", "通过-f/-ip加载的条目默认使用的协议(udp/tcp/tls/https/doh-json)，留空为udp；单条目可用 ip#协议 覆盖")
+    downloadURL := flag.String("url", "", "下载测速使用的URL，留空则跳过下载测速")
+    downloadSeconds := flag.Int("dt", 5, "下载测速持续时间(秒)")
+    disableDownload := flag.Bool("dd", false, "禁用下载测速，仅按延迟排序")
+    outputPath := flag.String("o", "result.csv", "结果导出路径(.csv或.json)，传入空格表示不导出")
+    liveMode := flag.Bool("live", false, "启用实时滚动排名，可在出现满意结果后按Ctrl-C提前退出")
+    flag.Parse()
+
+    color.Cyan("DNS服务器速度测试工具")
     fmt.Println(strings.Repeat("=", 60))
 
     tester := &DNSTester{}
     tester.LoadCustomDNS()
+    tester.Filters = FilterOptions{
+        MaxAvgLatency:   *maxAvgLatency,
+        MinAvgLatency:   *minAvgLatency,
+        MaxLossRatio:    *maxLossRatio,
+        MinSuccessCount: *minSuccessCount,
+        Region:          *region,
+        ResultCount:     *resultCount,
+    }
+
+    tester.IPv6Mode = *ipv6Mode
+    tester.AllIP = *allIP
+
+    tester.DefaultProtocol = strings.ToLower(strings.TrimSpace(*protocol))
+    if tester.DefaultProtocol != "" && !protocolSuffixes[tester.DefaultProtocol] {
+        fmt.Printf("-protocol=%s 不是合法协议，已重置为udp\n", *protocol)
+        tester.DefaultProtocol = ""
+    }
 
-    fmt.Print("开始测试DNS服务器响应时间（模拟）...")
-    results := tester.TestAllDNS(10) // 并发数为10
-    fmt.Println(" 完成!")
+    if *concurrency < 1 {
+        fmt.Printf("并发数 -n=%d 非法，已重置为1\n", *concurrency)
+        *concurrency = 1
+    }
+
+    if *dnsFile != "" {
+        if err := tester.LoadFromFile(*dnsFile); err != nil {
+            fmt.Printf("加载自定义DNS列表失败: %v\n", err)
+        }
+    } else if *ipList != "" {
+        if err := tester.LoadFromCIDR(strings.Split(*ipList, ",")); err != nil {
+            fmt.Printf("加载自定义DNS列表失败: %v\n", err)
+        }
+    }
+
+    var results []TestResult
+    if *liveMode {
+        results = tester.TestAllDNSLive(*concurrency, 500*time.Millisecond)
+    } else {
+        fmt.Print("开始测试DNS服务器响应时间...")
+        results = tester.TestAllDNS(*concurrency)
+        fmt.Println(" 完成!")
+    }
 
     tester.DisplayResults(results)
 
-    bestDNS := tester.GetBestDNS(results, 3)
-    if len(bestDNS) > 0 {
+    bestDNS := tester.GetBestDNS(results, *resultCount)
+    if len(bestDNS) == 0 {
+        fmt.Println("没有找到可用的DNS服务器")
+        if err := tester.ExportResults(results, *outputPath); err != nil {
+            fmt.Printf("导出结果失败: %v\n", err)
+        } else if strings.TrimSpace(*outputPath) != "" {
+            fmt.Printf("结果已导出至 %s\n", *outputPath)
+        }
+        return
+    }
+
+    exportResults := results
+    if !*disableDownload && *downloadURL != "" {
+        fmt.Printf("开始对前 %d 个DNS服务器进行下载测速 (%ds)...\n", len(bestDNS), *downloadSeconds)
+        bestDNS = tester.DownloadTest(bestDNS, len(bestDNS), *downloadURL, time.Duration(*downloadSeconds)*time.Second)
+        exportResults = bestDNS
+
+        fmt.Println()
+        fmt.Println("推荐的最快DNS服务器（按下载速度排序）:")
+        for i, best := range bestDNS {
+            fmt.Printf("%d. %s (%s) - %.2fms延迟, %.2fMB/s下载速度\n",
+                i+1, best.Server.Name, best.Server.IP,
+                best.AvgResponseTime, best.DownloadSpeed)
+        }
+    } else {
         fmt.Println()
         fmt.Println("推荐的最快DNS服务器:")
-        for i, dns := range bestDNS {
+        for i, best := range bestDNS {
             connectivityStatus := "异常"
-            if dns.Connectivity {
+            if best.Connectivity {
                 connectivityStatus = "正常"
             }
             fmt.Printf("%d. %s (%s) - %.2fms (成功率: %d%%, 连通性: %s)\n",
-                i+1, dns.Server.Name, dns.Server.IP,
-                dns.AvgResponseTime,
-                dns.SuccessCount*100/3,
+                i+1, best.Server.Name, best.Server.IP,
+                best.AvgResponseTime,
+                best.SuccessCount*100/len(tester.TestQueries),
                 connectivityStatus)
         }
-    } else {
-        fmt.Println("没有找到可用的DNS服务器")
     }
 
-    fmt.Println("\n注意：此版本为云端模拟版，实际DNS查询功能受限于云端环境限制。")
-    fmt.Println("如需完整功能，请在本地环境安装Go后运行完整版本。")
+    if err := tester.ExportResults(exportResults, *outputPath); err != nil {
+        fmt.Printf("导出结果失败: %v\n", err)
+    } else if strings.TrimSpace(*outputPath) != "" {
+        fmt.Printf("结果已导出至 %s\n", *outputPath)
+    }
 }